@@ -0,0 +1,569 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/utils"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
+	"yunion.io/x/onecloud/pkg/mcclient/modules/monitor"
+)
+
+type SScalingCompositeAlarmManager struct {
+	db.SStandaloneResourceBaseManager
+}
+
+// SScalingCompositeAlarm evaluates a boolean expression over several
+// SScalingAlarmSubCondition rows, e.g. "(c0 AND c1) OR c2", letting a
+// policy scale on a combination of metrics instead of a single
+// indicator as SScalingAlarm does.
+type SScalingCompositeAlarm struct {
+	db.SStandaloneResourceBase
+
+	SScalingPolicyBase
+
+	// Expression is a boolean expression (AND/OR/NOT, parentheses) over
+	// the ExprId of this alarm's sub-conditions
+	Expression string `width:"256" charset:"ascii"`
+
+	// pendingSubConditions holds the validated sub-condition DTOs from
+	// ValidateCreateData until Register persists them as child rows.
+	// Being unexported, it has no DB column of its own.
+	pendingSubConditions []api.ScalingAlarmSubConditionInput
+}
+
+type SScalingAlarmSubConditionManager struct {
+	db.SStandaloneResourceBaseManager
+}
+
+// SScalingAlarmSubCondition is one metric condition of a
+// SScalingCompositeAlarm, e.g. "cpu.avg > 80 for 3 cycles". It mirrors
+// the fields of SScalingAlarm but keeps its own breach state so that
+// sibling conditions don't share cumulate counters.
+type SScalingAlarmSubCondition struct {
+	db.SStandaloneResourceBase
+
+	// CompositeAlarmId is the owning SScalingCompositeAlarm
+	CompositeAlarmId string `width:"36" charset:"ascii"`
+
+	// ExprId is the short token (e.g. "c0") this sub-condition is
+	// referenced by in the owning alarm's Expression
+	ExprId string `width:"8" charset:"ascii"`
+
+	// ID of alarm config in alarm service
+	AlarmId string `width:"128" charset:"ascii"`
+
+	// Trigger when the cumulative count is reached
+	Cumulate  int
+	Cycle     int
+	Indicator string `width:"32" charset:"ascii"`
+
+	// Wrapper instruct how to calculate collective data based on individual data
+	Wrapper  string `width:"16" charset:"ascii"`
+	Operator string `width:"2" charset:"ascii"`
+
+	Value float64
+
+	// Measurement/Field/TagFilter are only used when Indicator is
+	// api.INDICATOR_CUSTOM, letting operators scale on any Telegraf
+	// measurement+field instead of the ones hardcoded in indicatorMap.
+	// TagFilter is a comma-separated list of "tag=value" pairs.
+	Measurement string `width:"32" charset:"ascii" nullable:"true"`
+	Field       string `width:"32" charset:"ascii" nullable:"true"`
+	TagFilter   string `width:"128" charset:"ascii" nullable:"true"`
+
+	// Real-time cumulate number
+	RealCumulate int `default:"0"`
+	// Last trigger time
+	LastTriggerTime time.Time
+}
+
+var (
+	ScalingCompositeAlarmManager    *SScalingCompositeAlarmManager
+	ScalingAlarmSubConditionManager *SScalingAlarmSubConditionManager
+)
+
+func init() {
+	ScalingCompositeAlarmManager = &SScalingCompositeAlarmManager{
+		SStandaloneResourceBaseManager: db.NewStandaloneResourceBaseManager(
+			SScalingCompositeAlarm{},
+			"scalingcompositealarms_tbl",
+			"scalingcompositealarm",
+			"scalingcompositealarms",
+		),
+	}
+	ScalingCompositeAlarmManager.SetVirtualObject(ScalingCompositeAlarmManager)
+
+	ScalingAlarmSubConditionManager = &SScalingAlarmSubConditionManager{
+		SStandaloneResourceBaseManager: db.NewStandaloneResourceBaseManager(
+			SScalingAlarmSubCondition{},
+			"scalingalarmsubconditions_tbl",
+			"scalingalarmsubcondition",
+			"scalingalarmsubconditions",
+		),
+	}
+	ScalingAlarmSubConditionManager.SetVirtualObject(ScalingAlarmSubConditionManager)
+}
+
+func (sca *SScalingCompositeAlarm) SubConditions() ([]SScalingAlarmSubCondition, error) {
+	q := ScalingAlarmSubConditionManager.Query().Equals("composite_alarm_id", sca.GetId())
+	var conditions []SScalingAlarmSubCondition
+	err := db.FetchModelObjects(ScalingAlarmSubConditionManager, q, &conditions)
+	return conditions, err
+}
+
+func (sca *SScalingCompositeAlarm) ValidateCreateData(input api.ScalingPolicyCreateInput) (api.ScalingPolicyCreateInput, error) {
+	if len(input.CompositeAlarm.SubConditions) == 0 {
+		return input, httperrors.NewInputParameterError("composite alarm requires at least one sub_condition")
+	}
+	validIds := make(map[string]bool, len(input.CompositeAlarm.SubConditions))
+	for i := range input.CompositeAlarm.SubConditions {
+		sc := &input.CompositeAlarm.SubConditions[i]
+		if len(sc.Operator) == 0 {
+			sc.Operator = api.OPERATOR_GT
+		}
+		if sc.Cycle == 0 {
+			sc.Cycle = 300
+		}
+		if !utils.IsInStringArray(sc.Operator, []string{api.OPERATOR_GT, api.OPERATOR_LT}) {
+			return input, httperrors.NewInputParameterError("unknown operator in sub_condition %q", sc.ExprId)
+		}
+		if !utils.IsInStringArray(sc.Wrapper, []string{api.WRAPPER_MIN, api.WRAPPER_MAX, api.WRAPPER_AVER}) {
+			return input, httperrors.NewInputParameterError("unknown wrapper in sub_condition %q", sc.ExprId)
+		}
+		if sc.Indicator == api.INDICATOR_CUSTOM {
+			if len(sc.Measurement) == 0 || len(sc.Field) == 0 {
+				return input, httperrors.NewInputParameterError("custom indicator requires measurement and field")
+			}
+		} else if _, ok := indicatorMap[sc.Indicator]; !ok {
+			return input, httperrors.NewInputParameterError("unknown indicator in sub_condition %q", sc.Indicator)
+		}
+		if sc.Cycle < 300 {
+			return input, httperrors.NewInputParameterError("the min value of cycle in sub_condition is 300")
+		}
+		if len(sc.ExprId) == 0 {
+			sc.ExprId = fmt.Sprintf("c%d", i)
+		}
+		if validIds[sc.ExprId] {
+			return input, httperrors.NewInputParameterError("duplicate sub_condition id %q", sc.ExprId)
+		}
+		validIds[sc.ExprId] = true
+	}
+	if _, err := parseCompositeExpr(input.CompositeAlarm.Expression, validIds); err != nil {
+		return input, httperrors.NewInputParameterError("invalid expression: %s", err)
+	}
+	// carried across to Register, which persists one
+	// SScalingAlarmSubCondition row per entry
+	sca.pendingSubConditions = input.CompositeAlarm.SubConditions
+	return input, nil
+}
+
+// createSubConditions builds and inserts one SScalingAlarmSubCondition
+// row per entry validated by ValidateCreateData, returning them ready
+// for Register to create monitor Alerts against.
+func (sca *SScalingCompositeAlarm) createSubConditions() ([]SScalingAlarmSubCondition, error) {
+	conditions := make([]SScalingAlarmSubCondition, 0, len(sca.pendingSubConditions))
+	for _, in := range sca.pendingSubConditions {
+		sc := SScalingAlarmSubCondition{
+			CompositeAlarmId: sca.GetId(),
+			ExprId:           in.ExprId,
+			Cumulate:         in.Cumulate,
+			Cycle:            in.Cycle,
+			Indicator:        in.Indicator,
+			Wrapper:          in.Wrapper,
+			Operator:         in.Operator,
+			Value:            in.Value,
+			Measurement:      in.Measurement,
+			Field:            in.Field,
+			TagFilter:        in.TagFilter,
+		}
+		if err := ScalingAlarmSubConditionManager.TableSpec().Insert(&sc); err != nil {
+			return nil, errors.Wrap(err, "STableSpec.Insert sub_condition")
+		}
+		conditions = append(conditions, sc)
+	}
+	return conditions, nil
+}
+
+func (sca *SScalingCompositeAlarm) Register(ctx context.Context, userCred mcclient.TokenCredential) error {
+	t := TimersFor(sca.ScalingPolicyId, "composite_alarm").Start("alarm_register")
+	defer t.Stop()
+
+	sp, err := sca.ScalingPolicy()
+	if err != nil {
+		t.Failed()
+		return err
+	}
+	session := auth.GetSession(ctx, userCred, "", "")
+	notificationID, err := ScalingPolicyManager.NotificationID(session)
+	if err != nil {
+		t.Failed()
+		return errors.Wrap(err, "ScalingPolicyManager.NotificationID")
+	}
+
+	err = ScalingCompositeAlarmManager.TableSpec().Insert(sca)
+	if err != nil {
+		t.Failed()
+		return errors.Wrap(err, "STableSpec.Insert composite alarm")
+	}
+
+	subConditions, err := sca.createSubConditions()
+	if err != nil {
+		t.Failed()
+		return errors.Wrap(err, "SScalingCompositeAlarm.createSubConditions")
+	}
+	for i := range subConditions {
+		sc := &subConditions[i]
+
+		// one monitor Alert per sub-condition, attached to the same
+		// notification as a plain SScalingAlarm would be
+		config, err := sc.generateAlertConfig(sp)
+		if err != nil {
+			t.Failed()
+			return errors.Wrap(err, "generateAlertConfig for sub_condition")
+		}
+		alert, err := monitor.Alerts.DoCreate(session, config)
+		if err != nil {
+			t.Failed()
+			return errors.Wrap(err, "create Alert for sub_condition failed")
+		}
+		alarmId, _ := alert.GetString("id")
+		params := jsonutils.NewDict()
+		params.Set("scaling_policy_id", jsonutils.NewString(sca.ScalingPolicyId))
+		detachParams := jsonutils.NewDict()
+		detachParams.Set("params", params)
+		if _, err := monitor.Alertnotification.Attach(session, alarmId, notificationID, detachParams); err != nil {
+			monitor.Alerts.Delete(session, alarmId, jsonutils.NewDict())
+			t.Failed()
+			return errors.Wrap(err, "attach alert with notification")
+		}
+		_, err = db.Update(sc, func() error {
+			sc.AlarmId = alarmId
+			return nil
+		})
+		if err != nil {
+			t.Failed()
+			return errors.Wrap(err, "db.Update sub_condition AlarmId")
+		}
+	}
+	t.Succeeded()
+	return nil
+}
+
+func (sca *SScalingCompositeAlarm) UnRegister(ctx context.Context, userCred mcclient.TokenCredential) error {
+	session := auth.GetSession(ctx, userCred, "", "")
+	subConditions, err := sca.SubConditions()
+	if err != nil {
+		return errors.Wrap(err, "SScalingCompositeAlarm.SubConditions")
+	}
+	for i := range subConditions {
+		sc := &subConditions[i]
+		if _, err := monitor.Alerts.Delete(session, sc.AlarmId, jsonutils.NewDict()); err != nil {
+			return errors.Wrap(err, "Alerts.Delete")
+		}
+		if err := sc.Delete(ctx, userCred); err != nil {
+			return errors.Wrap(err, "SScalingAlarmSubCondition.Delete")
+		}
+	}
+	if err := sca.Delete(ctx, userCred); err != nil {
+		return errors.Wrap(err, "SScalingCompositeAlarm.Delete")
+	}
+	return nil
+}
+
+func (sca *SScalingCompositeAlarm) TriggerId() string {
+	return sca.GetId()
+}
+
+func (sca *SScalingCompositeAlarm) TriggerDescription() string {
+	name := sca.ScalingPolicyId
+	sp, _ := sca.ScalingPolicy()
+	if sp != nil {
+		name = sp.Name
+	}
+	return fmt.Sprintf(`Composite alarm task(%s) execute scaling policy "%s"`, sca.Expression, name)
+}
+
+// IsTrigger advances every sub-condition's own breach state and
+// evaluates Expression against the result, so e.g. "c0 AND c1" only
+// fires once both sub-conditions have independently reached their own
+// Cumulate.
+func (sca *SScalingCompositeAlarm) IsTrigger() bool {
+	timer := TimersFor(sca.ScalingPolicyId, "composite_alarm").Start("is_trigger")
+	defer timer.Stop()
+
+	if sca.inCooldown(time.Now()) {
+		timer.Suppressed()
+		return false
+	}
+	subConditions, err := sca.SubConditions()
+	if err != nil {
+		log.Errorf("SScalingCompositeAlarm.IsTrigger: SubConditions failed: %s", err)
+		timer.Failed()
+		return false
+	}
+	validIds := make(map[string]bool, len(subConditions))
+	breached := make(map[string]bool, len(subConditions))
+	for i := range subConditions {
+		sc := &subConditions[i]
+		validIds[sc.ExprId] = true
+		breached[sc.ExprId] = sc.isBreached()
+	}
+	expr, err := parseCompositeExpr(sca.Expression, validIds)
+	if err != nil {
+		log.Errorf("SScalingCompositeAlarm.IsTrigger: invalid expression %q: %s", sca.Expression, err)
+		timer.Failed()
+		return false
+	}
+	is := expr.eval(breached)
+	if is {
+		timer.Succeeded()
+	}
+	return is
+}
+
+// isBreached advances this sub-condition's own cumulate counter and
+// reports whether it has reached Cumulate. It mirrors
+// SScalingAlarm.IsTrigger but is scoped to a single sub-condition row so
+// that siblings in the same composite alarm don't share state.
+func (sc *SScalingAlarmSubCondition) isBreached() bool {
+	realCumulate := sc.RealCumulate
+	now := time.Now()
+	if sc.LastTriggerTime.Add(time.Duration(sc.Cycle) * 2 * time.Second).Before(now) {
+		realCumulate = 1
+	} else {
+		realCumulate += 1
+	}
+	is := false
+	if realCumulate == sc.Cumulate {
+		is = true
+		realCumulate = 0
+	}
+	_, err := db.Update(sc, func() error {
+		sc.RealCumulate = realCumulate
+		sc.LastTriggerTime = now
+		return nil
+	})
+	if err != nil {
+		log.Errorf("db.Update in SScalingAlarmSubCondition.isBreached failed: %s", err.Error())
+	}
+	return is
+}
+
+// subConditionTableField resolves the telegraf measurement/field a
+// sub-condition should query: the hardcoded indicatorMap entry, or the
+// operator-supplied measurement/field when Indicator is
+// api.INDICATOR_CUSTOM.
+func subConditionTableField(indicator, measurement, field string) (sTableField, error) {
+	if indicator == api.INDICATOR_CUSTOM {
+		if len(measurement) == 0 || len(field) == 0 {
+			return sTableField{}, fmt.Errorf("custom indicator requires measurement and field")
+		}
+		return sTableField{Table: measurement, Field: field}, nil
+	}
+	tf, ok := indicatorMap[indicator]
+	if !ok {
+		return sTableField{}, fmt.Errorf("unknown indicator %q", indicator)
+	}
+	return tf, nil
+}
+
+func (sc *SScalingAlarmSubCondition) generateAlertConfig(sp *SScalingPolicy) (*monitor.AlertConfig, error) {
+	tf, err := subConditionTableField(sc.Indicator, sc.Measurement, sc.Field)
+	if err != nil {
+		return nil, err
+	}
+	config, err := monitor.NewAlertConfig(fmt.Sprintf("sp-%s-%s", sp.Id, sc.ExprId), fmt.Sprintf("%ds", sc.Cycle), true)
+	if err != nil {
+		return nil, err
+	}
+	cond := config.Condition("telegraf", tf.Table).Avg()
+	switch sc.Operator {
+	case api.OPERATOR_LT:
+		cond = cond.LT(sc.Value)
+	case api.OPERATOR_GT:
+		cond = cond.GT(sc.Value)
+	}
+	q := cond.Query().From(fmt.Sprintf("%ds", sc.Cycle))
+	sel := q.Selects().Select(tf.Field)
+	switch sc.Wrapper {
+	case api.WRAPPER_AVER:
+		sel = sel.MEAN()
+	case api.WRAPPER_MAX:
+		sel = sel.MAX()
+	case api.WRAPPER_MIN:
+		sel = sel.MIN()
+	}
+	q.Where().Equal("vm_scaling_group_id", sp.ScalingGroupId)
+	if sp.Warmup > 0 {
+		warmupCutoff := time.Now().Add(-time.Duration(sp.Warmup) * time.Second)
+		q.Where().LT("vm_created_at", warmupCutoff.Unix())
+	}
+	if sc.Indicator == api.INDICATOR_CUSTOM && len(sc.TagFilter) > 0 {
+		for _, kv := range strings.Split(sc.TagFilter, ",") {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) == 2 {
+				q.Where().Equal(parts[0], parts[1])
+			}
+		}
+	}
+	q.GroupBy().TAG("*").FILL_NULL()
+	return config, nil
+}
+
+// compositeExprNode is one node of a parsed Expression tree.
+type compositeExprNode interface {
+	eval(vars map[string]bool) bool
+}
+
+type compositeExprIdent string
+
+func (n compositeExprIdent) eval(vars map[string]bool) bool { return vars[string(n)] }
+
+type compositeExprNot struct{ operand compositeExprNode }
+
+func (n compositeExprNot) eval(vars map[string]bool) bool { return !n.operand.eval(vars) }
+
+type compositeExprBinary struct {
+	op          string // "AND" or "OR"
+	left, right compositeExprNode
+}
+
+func (n compositeExprBinary) eval(vars map[string]bool) bool {
+	switch n.op {
+	case "AND":
+		return n.left.eval(vars) && n.right.eval(vars)
+	case "OR":
+		return n.left.eval(vars) || n.right.eval(vars)
+	}
+	return false
+}
+
+func tokenizeCompositeExpr(expr string) []string {
+	expr = strings.ReplaceAll(expr, "(", " ( ")
+	expr = strings.ReplaceAll(expr, ")", " ) ")
+	return strings.Fields(expr)
+}
+
+type compositeExprParser struct {
+	tokens []string
+	pos    int
+}
+
+// parseCompositeExpr parses a boolean expression (AND/OR/NOT,
+// parentheses) over the sub-condition ids in validIds, e.g.
+// "(c0 AND c1) OR c2".
+func parseCompositeExpr(expr string, validIds map[string]bool) (compositeExprNode, error) {
+	p := &compositeExprParser{tokens: tokenizeCompositeExpr(expr)}
+	node, err := p.parseOr(validIds)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token %q in expression", p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+func (p *compositeExprParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *compositeExprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *compositeExprParser) parseOr(validIds map[string]bool) (compositeExprNode, error) {
+	left, err := p.parseAnd(validIds)
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "OR") {
+		p.next()
+		right, err := p.parseAnd(validIds)
+		if err != nil {
+			return nil, err
+		}
+		left = compositeExprBinary{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *compositeExprParser) parseAnd(validIds map[string]bool) (compositeExprNode, error) {
+	left, err := p.parseUnary(validIds)
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "AND") {
+		p.next()
+		right, err := p.parseUnary(validIds)
+		if err != nil {
+			return nil, err
+		}
+		left = compositeExprBinary{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *compositeExprParser) parseUnary(validIds map[string]bool) (compositeExprNode, error) {
+	if strings.EqualFold(p.peek(), "NOT") {
+		p.next()
+		operand, err := p.parseUnary(validIds)
+		if err != nil {
+			return nil, err
+		}
+		return compositeExprNot{operand: operand}, nil
+	}
+	return p.parsePrimary(validIds)
+}
+
+func (p *compositeExprParser) parsePrimary(validIds map[string]bool) (compositeExprNode, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case tok == "(":
+		node, err := p.parseOr(validIds)
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return node, nil
+	default:
+		if !validIds[tok] {
+			return nil, fmt.Errorf("expression references unknown sub-condition %q", tok)
+		}
+		return compositeExprIdent(tok), nil
+	}
+}
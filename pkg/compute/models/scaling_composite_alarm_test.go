@@ -0,0 +1,65 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "testing"
+
+func TestParseCompositeExprEval(t *testing.T) {
+	validIds := map[string]bool{"c0": true, "c1": true, "c2": true}
+	cases := []struct {
+		expr string
+		vars map[string]bool
+		want bool
+	}{
+		{"c0", map[string]bool{"c0": true}, true},
+		{"c0", map[string]bool{"c0": false}, false},
+		{"c0 AND c1", map[string]bool{"c0": true, "c1": false}, false},
+		{"c0 AND c1", map[string]bool{"c0": true, "c1": true}, true},
+		{"c0 OR c1", map[string]bool{"c0": false, "c1": true}, true},
+		{"NOT c0", map[string]bool{"c0": false}, true},
+		{"(c0 AND c1) OR c2", map[string]bool{"c0": false, "c1": true, "c2": true}, true},
+		{"(c0 AND c1) OR c2", map[string]bool{"c0": false, "c1": true, "c2": false}, false},
+		// AND binds tighter than OR
+		{"c0 OR c1 AND c2", map[string]bool{"c0": false, "c1": true, "c2": false}, false},
+		{"NOT (c0 OR c1)", map[string]bool{"c0": false, "c1": false}, true},
+		// lowercase operators are accepted
+		{"c0 and c1", map[string]bool{"c0": true, "c1": true}, true},
+	}
+	for _, c := range cases {
+		node, err := parseCompositeExpr(c.expr, validIds)
+		if err != nil {
+			t.Fatalf("parseCompositeExpr(%q) error: %v", c.expr, err)
+		}
+		if got := node.eval(c.vars); got != c.want {
+			t.Errorf("parseCompositeExpr(%q).eval(%v) = %v, want %v", c.expr, c.vars, got, c.want)
+		}
+	}
+}
+
+func TestParseCompositeExprErrors(t *testing.T) {
+	validIds := map[string]bool{"c0": true, "c1": true}
+	cases := []string{
+		"c0 AND",
+		"(c0 AND c1",
+		"c0 c1",
+		"c0 AND c2", // c2 is not a valid sub-condition id
+		"",
+	}
+	for _, expr := range cases {
+		if _, err := parseCompositeExpr(expr, validIds); err == nil {
+			t.Errorf("parseCompositeExpr(%q) expected an error, got nil", expr)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	scalingTriggerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "onecloud",
+			Subsystem: "scaling",
+			Name:      "trigger_stage_duration_seconds",
+			Help:      "Duration of a scaling trigger's lifecycle stage (register, is_trigger, next_time_recompute, ...)",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"policy_id", "trigger_type", "stage"},
+	)
+	scalingTriggerResult = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "onecloud",
+			Subsystem: "scaling",
+			Name:      "trigger_stage_result_total",
+			Help:      "Outcome of a scaling trigger's lifecycle stage",
+		},
+		[]string{"policy_id", "trigger_type", "stage", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(scalingTriggerDuration, scalingTriggerResult)
+}
+
+// stageTimer is a single running measurement returned by Timers.Start.
+// Stop records its duration; the optional Succeeded/Failed/Suppressed
+// calls additionally record the stage's outcome.
+type stageTimer struct {
+	policyId    string
+	triggerType string
+	stage       string
+	start       time.Time
+}
+
+func (t *stageTimer) Stop() time.Duration {
+	d := time.Since(t.start)
+	scalingTriggerDuration.WithLabelValues(t.policyId, t.triggerType, t.stage).Observe(d.Seconds())
+	return d
+}
+
+func (t *stageTimer) Succeeded()  { t.result("success") }
+func (t *stageTimer) Failed()     { t.result("failure") }
+func (t *stageTimer) Suppressed() { t.result("suppressed_by_cooldown") }
+
+func (t *stageTimer) result(result string) {
+	scalingTriggerResult.WithLabelValues(t.policyId, t.triggerType, t.stage, result).Inc()
+}
+
+// Timers is a per-SScalingPolicy, per-trigger-type handle onto the
+// scaling metrics subsystem. Every stage a trigger goes through
+// (register, unregister, is_trigger, next_time_recompute, ...) is timed
+// by calling Start and deferring Stop, e.g.:
+//
+//	t := TimersFor(sa.ScalingPolicyId, "alarm").Start("alarm_register")
+//	defer t.Stop()
+type Timers struct {
+	policyId    string
+	triggerType string
+}
+
+func (t *Timers) Start(stage string) *stageTimer {
+	return &stageTimer{policyId: t.policyId, triggerType: t.triggerType, stage: stage, start: time.Now()}
+}
+
+type sTimersCache struct {
+	mu    sync.Mutex
+	byKey map[string]*Timers
+}
+
+var scalingTimersCache = &sTimersCache{byKey: map[string]*Timers{}}
+
+// TimersFor returns the Timers scoped to policyId/triggerType, creating
+// it on first use so that every trigger of a given policy shares one
+// set of Prometheus label values instead of re-registering per call.
+func TimersFor(policyId, triggerType string) *Timers {
+	key := policyId + "/" + triggerType
+	scalingTimersCache.mu.Lock()
+	defer scalingTimersCache.mu.Unlock()
+	t, ok := scalingTimersCache.byKey[key]
+	if !ok {
+		t = &Timers{policyId: policyId, triggerType: triggerType}
+		scalingTimersCache.byKey[key] = t
+	}
+	return t
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"time"
+
+	"yunion.io/x/pkg/errors"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/httperrors"
+)
+
+type SScalingPolicyManager struct {
+	db.SStandaloneResourceBaseManager
+}
+
+// SScalingPolicy ties a scaling group to the trigger (SScalingTimer,
+// SScalingAlarm, SScalingCompositeAlarm, SScalingTargetTracking or
+// SScalingWebhook) that decides when it fires, plus the Cooldown/Warmup
+// windows shared by every trigger type regardless of which one is in
+// use.
+type SScalingPolicy struct {
+	db.SStandaloneResourceBase
+
+	ScalingGroupId string `width:"36" charset:"ascii"`
+
+	// Cooldown is the minimum number of seconds that must pass between
+	// two executions of this policy; triggers suppress themselves while
+	// still within this window of LastExecTime (SScalingPolicyBase.inCooldown)
+	Cooldown int `nullable:"false" default:"0"`
+
+	// Warmup excludes guests younger than this many seconds from the
+	// aggregate an alarm or composite-alarm trigger evaluates, so a newly
+	// added instance doesn't skew the metric before it has ramped up.
+	// SScalingTargetTracking's metric query does not apply it.
+	Warmup int `nullable:"false" default:"0"`
+
+	// LastExecTime is stamped by MarkExecuted once a scaling action this
+	// policy triggered actually runs, and is what Cooldown is measured
+	// from
+	LastExecTime time.Time
+}
+
+var ScalingPolicyManager *SScalingPolicyManager
+
+func init() {
+	ScalingPolicyManager = &SScalingPolicyManager{
+		SStandaloneResourceBaseManager: db.NewStandaloneResourceBaseManager(
+			SScalingPolicy{},
+			"scalingpolicies_tbl",
+			"scalingpolicy",
+			"scalingpolicies",
+		),
+	}
+	ScalingPolicyManager.SetVirtualObject(ScalingPolicyManager)
+}
+
+// ValidateCreateData validates the Cooldown/Warmup fields shared by
+// every trigger type. It runs before the policy's chosen trigger
+// validates its own type-specific part of input.
+func (spm *SScalingPolicyManager) ValidateCreateData(input api.ScalingPolicyCreateInput) (api.ScalingPolicyCreateInput, error) {
+	if input.Cooldown < 0 {
+		return input, httperrors.NewInputParameterError("cooldown must not be negative")
+	}
+	if input.Warmup < 0 {
+		return input, httperrors.NewInputParameterError("warmup must not be negative")
+	}
+	return input, nil
+}
+
+// MarkExecuted stamps LastExecTime so Cooldown is measured from this
+// scaling action. The scaling task executor must call this once a
+// policy's triggered action actually completes -- a trigger's IsTrigger
+// returning true is a decision to act, not the action itself.
+func (sp *SScalingPolicy) MarkExecuted(now time.Time) error {
+	_, err := db.Update(sp, func() error {
+		sp.LastExecTime = now
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "db.Update LastExecTime")
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/utils"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
+	"yunion.io/x/onecloud/pkg/mcclient/modules/monitor"
+)
+
+type SScalingTargetTrackingManager struct {
+	db.SStandaloneResourceBaseManager
+}
+
+// SScalingTargetTracking is the autoscaler-style counterpart of
+// SScalingAlarm: instead of a threshold + cumulate-cycles + fixed
+// instance delta, it drives the group toward a target value of
+// Indicator by periodically computing the instance count required to
+// reach it, the way AWS/GCP target-tracking policies work.
+type SScalingTargetTracking struct {
+	db.SStandaloneResourceBase
+
+	SScalingPolicyBase
+
+	Indicator string `width:"32" charset:"ascii"`
+	Wrapper   string `width:"16" charset:"ascii"`
+
+	// TargetValue is the desired steady-state value of Indicator, e.g.
+	// 50 for "keep average CPU utilization at 50%"
+	TargetValue float64
+
+	MinSize int `nullable:"false"`
+	MaxSize int `nullable:"false"`
+
+	// Tolerance is the fraction (0-1) the computed instance count may
+	// deviate from the current size before a scaling action is taken
+	Tolerance float64
+
+	// StabilizationWindow is how long, in seconds, a computed delta must
+	// persist before being acted on
+	StabilizationWindow int
+
+	// StepLimit bounds how many instances a single reaction may add or
+	// remove; 0 means unlimited
+	StepLimit int
+
+	// PendingDelta/PendingSince track a delta that has exceeded
+	// Tolerance but hasn't yet persisted for StabilizationWindow
+	PendingDelta int
+	PendingSince time.Time
+
+	// TargetCount is the absolute instance count computed by the most
+	// recent IsTrigger() call that decided to act, and is persisted so
+	// it can be read back after the IsTrigger call returns. Nothing in
+	// this package reads it or scales the group to it.
+	TargetCount int
+}
+
+var ScalingTargetTrackingManager *SScalingTargetTrackingManager
+
+func init() {
+	ScalingTargetTrackingManager = &SScalingTargetTrackingManager{
+		SStandaloneResourceBaseManager: db.NewStandaloneResourceBaseManager(
+			SScalingTargetTracking{},
+			"scalingtargettrackings_tbl",
+			"scalingtargettracking",
+			"scalingtargettrackings",
+		),
+	}
+	ScalingTargetTrackingManager.SetVirtualObject(ScalingTargetTrackingManager)
+}
+
+func (stt *SScalingTargetTracking) ValidateCreateData(input api.ScalingPolicyCreateInput) (api.ScalingPolicyCreateInput, error) {
+	tt := input.TargetTracking
+	if _, ok := indicatorMap[tt.Indicator]; !ok {
+		return input, httperrors.NewInputParameterError("unknown indicator %q", tt.Indicator)
+	}
+	if !utils.IsInStringArray(tt.Wrapper, []string{api.WRAPPER_MIN, api.WRAPPER_MAX, api.WRAPPER_AVER}) {
+		return input, httperrors.NewInputParameterError("unknown wrapper %q", tt.Wrapper)
+	}
+	if tt.TargetValue <= 0 {
+		return input, httperrors.NewInputParameterError("target_value must be positive")
+	}
+	if tt.MinSize < 0 || tt.MaxSize < tt.MinSize {
+		return input, httperrors.NewInputParameterError("invalid min_size/max_size")
+	}
+	if tt.Tolerance <= 0 || tt.Tolerance >= 1 {
+		return input, httperrors.NewInputParameterError("tolerance must be in (0, 1)")
+	}
+	return input, nil
+}
+
+func (stt *SScalingTargetTracking) Register(ctx context.Context, userCred mcclient.TokenCredential) error {
+	t := TimersFor(stt.ScalingPolicyId, "target_tracking").Start("register")
+	defer t.Stop()
+
+	err := ScalingTargetTrackingManager.TableSpec().Insert(stt)
+	if err != nil {
+		t.Failed()
+		return errors.Wrap(err, "STableSpec.Insert")
+	}
+	t.Succeeded()
+	return nil
+}
+
+func (stt *SScalingTargetTracking) UnRegister(ctx context.Context, userCred mcclient.TokenCredential) error {
+	err := stt.Delete(ctx, userCred)
+	if err != nil {
+		return errors.Wrap(err, "SScalingTargetTracking.Delete")
+	}
+	return nil
+}
+
+func (stt *SScalingTargetTracking) TriggerId() string {
+	return stt.GetId()
+}
+
+func (stt *SScalingTargetTracking) TriggerDescription() string {
+	name := stt.ScalingPolicyId
+	sp, _ := stt.ScalingPolicy()
+	if sp != nil {
+		name = sp.Name
+	}
+	return fmt.Sprintf(
+		`Target-tracking task(keep the %s %s at %.2f%s) execute scaling policy "%s"`,
+		descs[stt.Wrapper], descs[stt.Indicator], stt.TargetValue, units[stt.Indicator], name,
+	)
+}
+
+// scalingGroupSize returns the number of guests currently in sg, the
+// "currentCount" the target-tracking formula scales from.
+func scalingGroupSize(sg *SScalingGroup) (int, error) {
+	q := GuestManager.Query().Equals("scaling_group_id", sg.Id)
+	return q.CountWithError()
+}
+
+// currentMetric queries the monitor service for the group's current
+// aggregated indicator value, the same measurement/field/wrapper
+// SScalingAlarm would alert on, but pulled on demand instead of via a
+// standing Alert rule.
+func (stt *SScalingTargetTracking) currentMetric(session *mcclient.ClientSession, sp *SScalingPolicy) (float64, error) {
+	tf, ok := indicatorMap[stt.Indicator]
+	if !ok {
+		return 0, fmt.Errorf("unknown indicator %q", stt.Indicator)
+	}
+	params := jsonutils.NewDict()
+	params.Set("measurement", jsonutils.NewString(tf.Table))
+	params.Set("field", jsonutils.NewString(tf.Field))
+	params.Set("wrapper", jsonutils.NewString(stt.Wrapper))
+	params.Set("scaling_group_id", jsonutils.NewString(sp.ScalingGroupId))
+	result, err := monitor.Queries.PerformClassAction(session, "group-indicator", params)
+	if err != nil {
+		return 0, errors.Wrap(err, "Queries.PerformClassAction group-indicator")
+	}
+	return result.Float("value")
+}
+
+// IsTrigger pulls the group's current aggregated indicator, computes the
+// instance count required to bring it to TargetValue, and returns true
+// once that delta exceeds Tolerance and has persisted for
+// StabilizationWindow. When it returns true, TargetCount holds the
+// absolute instance count the executor should scale the group to.
+func (stt *SScalingTargetTracking) IsTrigger() bool {
+	timer := TimersFor(stt.ScalingPolicyId, "target_tracking").Start("is_trigger")
+	defer timer.Stop()
+
+	now := time.Now()
+	if stt.inCooldown(now) {
+		timer.Suppressed()
+		return false
+	}
+	sp, err := stt.ScalingPolicy()
+	if err != nil {
+		log.Errorf("SScalingTargetTracking.IsTrigger: ScalingPolicy failed: %s", err.Error())
+		timer.Failed()
+		return false
+	}
+	sg, err := stt.ScalingGroup()
+	if err != nil {
+		log.Errorf("SScalingTargetTracking.IsTrigger: ScalingGroup failed: %s", err.Error())
+		timer.Failed()
+		return false
+	}
+	currentCount, err := scalingGroupSize(sg)
+	if err != nil {
+		log.Errorf("SScalingTargetTracking.IsTrigger: scalingGroupSize failed: %s", err.Error())
+		timer.Failed()
+		return false
+	}
+
+	session := auth.GetSession(context.Background(), auth.AdminCredential(), "", "")
+	metric, err := stt.currentMetric(session, sp)
+	if err != nil {
+		log.Errorf("SScalingTargetTracking.IsTrigger: currentMetric failed: %s", err.Error())
+		timer.Failed()
+		return false
+	}
+
+	_, rawDelta, actionDelta := scalingTargetTrackingDecision(
+		currentCount, metric, stt.TargetValue, stt.MinSize, stt.MaxSize, stt.StepLimit)
+
+	if withinTolerance(currentCount, rawDelta, stt.Tolerance) {
+		stt.resetPending()
+		return false
+	}
+
+	// hysteresis: the delta must be the same and persist for
+	// StabilizationWindow before acting, so one noisy sample doesn't
+	// cause a scaling flap
+	if stt.PendingDelta != rawDelta || stt.PendingSince.IsZero() {
+		stt.setPending(rawDelta, now)
+		return false
+	}
+	if stt.StabilizationWindow > 0 && now.Before(stt.PendingSince.Add(time.Duration(stt.StabilizationWindow)*time.Second)) {
+		return false
+	}
+
+	targetCount := currentCount + actionDelta
+	_, err = db.Update(stt, func() error {
+		stt.TargetCount = targetCount
+		return nil
+	})
+	if err != nil {
+		log.Errorf("SScalingTargetTracking.IsTrigger: db.Update TargetCount failed: %s", err.Error())
+		timer.Failed()
+		return false
+	}
+	stt.resetPending()
+	timer.Succeeded()
+	return true
+}
+
+// scalingTargetTrackingDecision is the pure arithmetic core of
+// IsTrigger's control loop: given the group's current size and current
+// metric value, it computes the desired instance count, the raw
+// (unclamped) delta from currentCount that tolerance and hysteresis are
+// evaluated against, and the StepLimit-clamped delta that's actually
+// requested from the executor. A group scaled to zero can't be
+// extrapolated from (metric/currentCount is undefined), so it's
+// special-cased to target MinSize instead.
+func scalingTargetTrackingDecision(currentCount int, metric, targetValue float64, minSize, maxSize, stepLimit int) (desired, rawDelta, actionDelta int) {
+	if currentCount == 0 {
+		desired = minSize
+	} else {
+		desired = int(math.Ceil(float64(currentCount) * metric / targetValue))
+	}
+	if desired < minSize {
+		desired = minSize
+	}
+	if desired > maxSize {
+		desired = maxSize
+	}
+	rawDelta = desired - currentCount
+	actionDelta = rawDelta
+	if stepLimit > 0 {
+		if actionDelta > stepLimit {
+			actionDelta = stepLimit
+		} else if actionDelta < -stepLimit {
+			actionDelta = -stepLimit
+		}
+	}
+	return
+}
+
+// withinTolerance reports whether rawDelta is small enough, relative to
+// currentCount, that no scaling action should be taken. A zero-sized
+// group has no meaningful relative tolerance, so it's only "within
+// tolerance" when there's no drift at all (desired is also MinSize 0).
+//
+// ValidateCreateData now rejects Tolerance <= 0 outright, so tolerance
+// here should always be a valid (0, 1) fraction; the fallback below only
+// guards rows persisted before that validation existed.
+func withinTolerance(currentCount, rawDelta int, tolerance float64) bool {
+	if tolerance <= 0 {
+		tolerance = 0.1
+	}
+	if currentCount == 0 {
+		return rawDelta == 0
+	}
+	return math.Abs(float64(rawDelta)) < float64(currentCount)*tolerance
+}
+
+func (stt *SScalingTargetTracking) setPending(delta int, since time.Time) {
+	_, err := db.Update(stt, func() error {
+		stt.PendingDelta = delta
+		stt.PendingSince = since
+		return nil
+	})
+	if err != nil {
+		log.Errorf("db.Update in SScalingTargetTracking.setPending failed: %s", err.Error())
+	}
+}
+
+func (stt *SScalingTargetTracking) resetPending() {
+	if stt.PendingDelta == 0 && stt.PendingSince.IsZero() {
+		return
+	}
+	_, err := db.Update(stt, func() error {
+		stt.PendingDelta = 0
+		stt.PendingSince = time.Time{}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("db.Update in SScalingTargetTracking.resetPending failed: %s", err.Error())
+	}
+}
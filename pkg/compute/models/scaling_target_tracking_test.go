@@ -0,0 +1,99 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "testing"
+
+func TestScalingTargetTrackingDecision(t *testing.T) {
+	cases := []struct {
+		name                                    string
+		currentCount                            int
+		metric, targetValue                     float64
+		minSize, maxSize, stepLimit             int
+		wantDesired, wantRawDelta, wantActionDelta int
+	}{
+		{
+			name: "at target, no delta",
+			currentCount: 4, metric: 50, targetValue: 50,
+			minSize: 1, maxSize: 10, stepLimit: 0,
+			wantDesired: 4, wantRawDelta: 0, wantActionDelta: 0,
+		},
+		{
+			name: "scale up clamped by MaxSize",
+			currentCount: 4, metric: 100, targetValue: 25,
+			minSize: 1, maxSize: 10, stepLimit: 0,
+			wantDesired: 10, wantRawDelta: 6, wantActionDelta: 6,
+		},
+		{
+			name: "scale down clamped by MinSize",
+			currentCount: 4, metric: 5, targetValue: 50,
+			minSize: 2, maxSize: 10, stepLimit: 0,
+			wantDesired: 2, wantRawDelta: -2, wantActionDelta: -2,
+		},
+		{
+			name: "StepLimit clamps actionDelta but not rawDelta",
+			currentCount: 4, metric: 200, targetValue: 25,
+			minSize: 1, maxSize: 100, stepLimit: 2,
+			wantDesired: 32, wantRawDelta: 28, wantActionDelta: 2,
+		},
+		{
+			name: "zero-size group recovers towards MinSize",
+			currentCount: 0, metric: 0, targetValue: 50,
+			minSize: 3, maxSize: 10, stepLimit: 0,
+			wantDesired: 3, wantRawDelta: 3, wantActionDelta: 3,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			desired, rawDelta, actionDelta := scalingTargetTrackingDecision(
+				c.currentCount, c.metric, c.targetValue, c.minSize, c.maxSize, c.stepLimit)
+			if desired != c.wantDesired || rawDelta != c.wantRawDelta || actionDelta != c.wantActionDelta {
+				t.Fatalf("got (desired=%d, rawDelta=%d, actionDelta=%d), want (%d, %d, %d)",
+					desired, rawDelta, actionDelta, c.wantDesired, c.wantRawDelta, c.wantActionDelta)
+			}
+		})
+	}
+}
+
+func TestWithinTolerance(t *testing.T) {
+	cases := []struct {
+		name                   string
+		currentCount, rawDelta int
+		tolerance              float64
+		want                   bool
+	}{
+		{"small drift within explicit tolerance", 10, 1, 0.2, true},
+		{"large drift outside explicit tolerance", 10, 5, 0.2, false},
+		{"tolerance <= 0 falls back to default 0.1", 10, 1, 0, false},
+		{"zero-size group with no drift is within tolerance", 0, 0, 0.1, true},
+		{"zero-size group with drift is never within tolerance", 0, 3, 0.1, false},
+		{
+			// a drift that StepLimit would clamp down to a small actionDelta
+			// must still be judged against the true rawDelta, not the
+			// clamped value, so it isn't mistaken for "within tolerance"
+			name: "large rawDelta is not within tolerance even if StepLimit would clamp it small",
+			currentCount: 10, rawDelta: 28, tolerance: 0.2, want: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := withinTolerance(c.currentCount, c.rawDelta, c.tolerance)
+			if got != c.want {
+				t.Fatalf("withinTolerance(%d, %d, %v) = %v, want %v",
+					c.currentCount, c.rawDelta, c.tolerance, got, c.want)
+			}
+		})
+	}
+}
@@ -88,6 +88,23 @@ func (spb *SScalingPolicyBase) ScalingPolicy() (*SScalingPolicy, error) {
 	return model.(*SScalingPolicy), nil
 }
 
+// inCooldown reports whether now still falls within the owning policy's
+// Cooldown window following its last execution. Triggers consult this
+// before firing so a just-triggered alarm can't immediately retrigger on
+// stale metrics, closing the thrashing gap where RealCumulate resets to
+// 0 but nothing else suppresses a fresh trigger.
+func (spb *SScalingPolicyBase) inCooldown(now time.Time) bool {
+	sp, err := spb.ScalingPolicy()
+	if err != nil {
+		log.Errorf("SScalingPolicyBase.inCooldown: ScalingPolicy failed: %s", err.Error())
+		return false
+	}
+	if sp.Cooldown <= 0 || sp.LastExecTime.IsZero() {
+		return false
+	}
+	return now.Before(sp.LastExecTime.Add(time.Duration(sp.Cooldown) * time.Second))
+}
+
 type SScalingTimerManager struct {
 	db.SStandaloneResourceBaseManager
 }
@@ -112,6 +129,12 @@ type SScalingTimer struct {
 	// 0-31 0 is unlimited
 	MonthDays uint32 `nullable:"false"`
 
+	// CronExpr holds a standard 5-field cron expression ("minute hour
+	// dom month dow") and is only meaningful when Type is
+	// api.TIMER_TYPE_CRON. It supersedes Minute/Hour/WeekDays/MonthDays
+	// for that timer type.
+	CronExpr string `width:"64" charset:"ascii"`
+
 	// StartTime represent the start time of this timer
 	StartTime time.Time
 
@@ -199,6 +222,9 @@ func (st *SScalingTimer) SetMonthDays(days []int) {
 
 // Update will update the SScalingTimer
 func (st *SScalingTimer) Update(now time.Time) {
+	t := TimersFor(st.ScalingPolicyId, "timer").Start("next_time_recompute")
+	defer t.Stop()
+
 	if now.IsZero() {
 		now = time.Now()
 	}
@@ -213,6 +239,29 @@ func (st *SScalingTimer) Update(now time.Time) {
 		return
 	}
 
+	if st.Type == api.TIMER_TYPE_CRON {
+		spec, err := parseCronExpr(st.CronExpr)
+		if err != nil {
+			log.Errorf("SScalingTimer.Update: invalid cron_expr %q: %s", st.CronExpr, err)
+			st.IsExpired = true
+			t.Failed()
+			return
+		}
+		newNextTime := st.nextCronTime(spec, now)
+		if newNextTime.IsZero() {
+			// no matching time found within the search horizon
+			st.IsExpired = true
+			t.Failed()
+			return
+		}
+		st.NextTime = newNextTime
+		if st.NextTime.After(st.EndTime) {
+			st.IsExpired = true
+		}
+		t.Succeeded()
+		return
+	}
+
 	newNextTime := time.Date(now.Year(), now.Month(), now.Day(), st.Hour, st.Minute, 0, 0, now.Location())
 	if now.After(newNextTime) {
 		newNextTime = newNextTime.AddDate(0, 0, 1)
@@ -254,6 +303,7 @@ func (st *SScalingTimer) Update(now time.Time) {
 	if st.NextTime.After(st.EndTime) {
 		st.IsExpired = true
 	}
+	t.Succeeded()
 }
 
 // MonthDaySum calculate the number of month's days
@@ -261,7 +311,7 @@ func (st *SScalingTimer) MonthDaySum(t time.Time) int {
 	year, month := t.Year(), t.Month()
 	monthDays := []int{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
 	if month != 2 {
-		return monthDays[2]
+		return monthDays[month-1]
 	}
 	if year%4 != 0 || (year%100 == 0 && year%400 != 0) {
 		return 28
@@ -269,6 +319,193 @@ func (st *SScalingTimer) MonthDaySum(t time.Time) int {
 	return 29
 }
 
+// cronField is one of the 5 fields of a parsed cron expression.
+type cronField struct {
+	// any is true for "*"
+	any bool
+	// last is true for "L", meaning the last value of the period (e.g.
+	// the last day of the month)
+	last   bool
+	values map[int]bool
+}
+
+// match reports whether v satisfies the field, given the maximum value
+// of the current period (e.g. the number of days in the current month,
+// used to resolve "L").
+func (cf cronField) match(v, periodMax int) bool {
+	switch {
+	case cf.any:
+		return true
+	case cf.last:
+		return v == periodMax
+	default:
+		return cf.values[v]
+	}
+}
+
+// matchDow is like match but treats 0 and 7 as the same weekday (Sunday),
+// matching the common cron convention.
+func (cf cronField) matchDow(weekday int) bool {
+	if cf.any {
+		return true
+	}
+	if cf.values[weekday] {
+		return true
+	}
+	if weekday == 0 {
+		return cf.values[7]
+	}
+	if weekday == 7 {
+		return cf.values[0]
+	}
+	return false
+}
+
+// restricted reports whether the field is anything other than "*"/"?",
+// i.e. whether it actually narrows down which days match.
+func (cf cronField) restricted() bool {
+	return !cf.any
+}
+
+func parseCronField(field string, min, max int) (cronField, error) {
+	cf := cronField{values: map[int]bool{}}
+	if field == "*" || field == "?" {
+		cf.any = true
+		return cf, nil
+	}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		rng := part
+		if idx := strings.Index(part, "/"); idx != -1 {
+			rng = part[:idx]
+			s, err := strconv.Atoi(part[idx+1:])
+			if err != nil || s <= 0 {
+				return cf, fmt.Errorf("invalid step in cron field %q", field)
+			}
+			step = s
+		}
+		if rng == "L" {
+			cf.last = true
+			continue
+		}
+		var lo, hi int
+		switch {
+		case rng == "*":
+			lo, hi = min, max
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			l, err1 := strconv.Atoi(bounds[0])
+			h, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return cf, fmt.Errorf("invalid range in cron field %q", field)
+			}
+			lo, hi = l, h
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return cf, fmt.Errorf("invalid value in cron field %q", field)
+			}
+			lo, hi = v, v
+		}
+		if lo < min || hi > max || lo > hi {
+			return cf, fmt.Errorf("cron field %q out of range [%d,%d]", field, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			cf.values[v] = true
+		}
+	}
+	return cf, nil
+}
+
+type cronSpec struct {
+	minute cronField
+	hour   cronField
+	dom    cronField
+	month  cronField
+	dow    cronField
+}
+
+// parseCronExpr parses a standard 5-field cron expression: "minute hour
+// day-of-month month day-of-week". It supports "*", ranges ("a-b"),
+// lists ("a,b,c"), steps ("*/n") and, for day-of-month, "L" (last day of
+// the month) and "?" (equivalent to "*").
+func parseCronExpr(expr string) (cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSpec{}, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	var spec cronSpec
+	var err error
+	if spec.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return spec, err
+	}
+	if spec.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return spec, err
+	}
+	if spec.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return spec, err
+	}
+	if spec.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return spec, err
+	}
+	if spec.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return spec, err
+	}
+	return spec, nil
+}
+
+// dayMatches reports whether t's day-of-month/day-of-week satisfies spec,
+// following standard cron semantics: when both fields are restricted
+// (neither is "*"/"?"), a day matches if EITHER one is satisfied (e.g.
+// "0 0 1,15 * 1" means "the 1st/15th of the month OR every Monday").
+// When only one field is restricted, that field alone decides; when
+// neither is, every day matches.
+func (spec cronSpec) dayMatches(t time.Time, lastDayOfMonth int) bool {
+	domOk := spec.dom.match(t.Day(), lastDayOfMonth)
+	dowOk := spec.dow.matchDow(int(t.Weekday()))
+	if spec.dom.restricted() && spec.dow.restricted() {
+		return domOk || dowOk
+	}
+	return domOk && dowOk
+}
+
+// cronSearchHorizon bounds how far nextCronTime will search before giving
+// up, so that expressions with no solution (e.g. "0 0 30 2 *") terminate
+// instead of looping forever.
+const cronSearchHorizon = 4 * 365 * 24 * time.Hour
+
+// nextCronTime finds the first whole minute after the one containing
+// from that satisfies spec, walking the clock forward in the timer's Location so that
+// DST transitions are handled the same way time.Date/AddDate handle them
+// natively. It returns the zero Time if nothing matches within
+// cronSearchHorizon.
+func (st *SScalingTimer) nextCronTime(spec cronSpec, from time.Time) time.Time {
+	loc := from.Location()
+	t := time.Date(from.Year(), from.Month(), from.Day(), from.Hour(), from.Minute(), 0, 0, loc).Add(time.Minute)
+	deadline := from.Add(cronSearchHorizon)
+	for t.Before(deadline) {
+		if !spec.month.match(int(t.Month()), 12) {
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc).AddDate(0, 1, 0)
+			continue
+		}
+		lastDay := st.MonthDaySum(t)
+		if !spec.dayMatches(t, lastDay) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1)
+			continue
+		}
+		if !spec.hour.match(t.Hour(), 23) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(time.Hour)
+			continue
+		}
+		if !spec.minute.match(t.Minute(), 59) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
 func (st *SScalingTimer) TimerDetails() api.ScalingTimerDetails {
 	return api.ScalingTimerDetails{ExecTime: st.EndTime}
 }
@@ -279,6 +516,7 @@ func (st *SScalingTimer) CycleTimerDetails() api.ScalingCycleTimerDetails {
 		Hour:      st.Hour,
 		WeekDays:  st.GetWeekDays(),
 		MonthDays: st.GetMonthDays(),
+		CronExpr:  st.CronExpr,
 		StartTime: st.StartTime,
 		EndTime:   st.EndTime,
 		CycleType: st.Type,
@@ -325,6 +563,12 @@ func (st *SScalingTimer) ValidateCreateData(input api.ScalingPolicyCreateInput)
 			return input, fmt.Errorf("month_days should not be empty")
 		}
 		input.CycleTimer.WeekDays = []int{}
+	case api.TIMER_TYPE_CRON:
+		if _, err := parseCronExpr(input.CycleTimer.CronExpr); err != nil {
+			return input, httperrors.NewInputParameterError("invalid cron_expr: %s", err)
+		}
+		input.CycleTimer.WeekDays = []int{}
+		input.CycleTimer.MonthDays = []int{}
 	default:
 		return input, fmt.Errorf("unkown cycle type %s", input.CycleTimer.CycleType)
 	}
@@ -369,6 +613,8 @@ func (st *SScalingTimer) TriggerDescription() string {
 		detail = st.WeekDaysDesc()
 	case api.TIMER_TYPE_MONTH:
 		detail = st.MonthDaysDesc()
+	case api.TIMER_TYPE_CRON:
+		detail = fmt.Sprintf("cron(%s)", st.CronExpr)
 	}
 	name := st.ScalingPolicyId
 	sp, _ := st.ScalingPolicy()
@@ -379,7 +625,7 @@ func (st *SScalingTimer) TriggerDescription() string {
 }
 
 func (st *SScalingTimer) IsTrigger() bool {
-	return true
+	return !st.inCooldown(time.Now())
 }
 
 func (sa *SScalingAlarm) ValidateCreateData(input api.ScalingPolicyCreateInput) (api.ScalingPolicyCreateInput, error) {
@@ -392,7 +638,7 @@ func (sa *SScalingAlarm) ValidateCreateData(input api.ScalingPolicyCreateInput)
 	if !utils.IsInStringArray(input.Alarm.Operator, []string{api.OPERATOR_GT, api.OPERATOR_LT}) {
 		return input, httperrors.NewInputParameterError("unkown operator in alarm %s", input.Alarm.Operator)
 	}
-	if !utils.IsInStringArray(input.Alarm.Indicator, []string{api.INDICATOR_CPU, api.INDICATOR_DISK_READ,
+	if !utils.IsInStringArray(input.Alarm.Indicator, []string{api.INDICATOR_CPU, api.INDICATOR_MEM, api.INDICATOR_DISK_READ,
 		api.INDICATOR_DISK_WRITE, api.INDICATOR_FLOW_INTO, api.INDICATOR_FLOW_OUT}) {
 		return input, httperrors.NewInputParameterError("unkown indicator in alarm %s", input.Alarm.Indicator)
 	}
@@ -437,22 +683,29 @@ func (spm *SScalingPolicyManager) NotificationID(session *mcclient.ClientSession
 }
 
 func (sa *SScalingAlarm) Register(ctx context.Context, userCred mcclient.TokenCredential) error {
+	t := TimersFor(sa.ScalingPolicyId, "alarm").Start("alarm_register")
+	defer t.Stop()
+
 	sp, err := sa.ScalingPolicy()
 	if err != nil {
+		t.Failed()
 		return err
 	}
 	session := auth.GetSession(ctx, userCred, "", "")
 	notificationID, err := ScalingPolicyManager.NotificationID(session)
 	if err != nil {
+		t.Failed()
 		return errors.Wrap(err, "ScalingPolicyManager.NotificationID")
 	}
 	// create Alert
 	config, err := sa.generateAlertConfig(sp)
 	if err != nil {
+		t.Failed()
 		return errors.Wrap(err, "ScalingAlarm.generateAlertConfig")
 	}
 	alert, err := monitor.Alerts.DoCreate(session, config)
 	if err != nil {
+		t.Failed()
 		return errors.Wrap(err, "create Alert failed")
 	}
 	alarmId, _ := alert.GetString("id")
@@ -464,6 +717,7 @@ func (sa *SScalingAlarm) Register(ctx context.Context, userCred mcclient.TokenCr
 	_, err = monitor.Alertnotification.Attach(session, alarmId, notificationID, detachParams)
 	if err != nil {
 		monitor.Alerts.Delete(session, alarmId, jsonutils.NewDict())
+		t.Failed()
 		return errors.Wrap(err, "attach alert with notification")
 	}
 	sa.AlarmId = alarmId
@@ -471,9 +725,11 @@ func (sa *SScalingAlarm) Register(ctx context.Context, userCred mcclient.TokenCr
 	// insert
 	err = ScalingAlarmManager.TableSpec().Insert(sa)
 	if err != nil {
+		t.Failed()
 		return errors.Wrap(err, "STableSpec.Insert")
 	}
 
+	t.Succeeded()
 	return nil
 }
 
@@ -484,6 +740,7 @@ type sTableField struct {
 
 var indicatorMap = map[string]sTableField{
 	api.INDICATOR_CPU:        {"vm_cpu", "usage_active"},
+	api.INDICATOR_MEM:        {"vm_mem", "used_percent"},
 	api.INDICATOR_DISK_WRITE: {"vm_diskio", "write_bps"},
 	api.INDICATOR_DISK_READ:  {"vm_diskio", "read_bps"},
 	api.INDICATOR_FLOW_INTO:  {"vm_netio", "bps_recv"},
@@ -515,6 +772,12 @@ func (sa *SScalingAlarm) generateAlertConfig(sp *SScalingPolicy) (*monitor.Alert
 		sel = sel.MIN()
 	}
 	q.Where().Equal("vm_scaling_group_id", sp.ScalingGroupId)
+	if sp.Warmup > 0 {
+		// exclude instances still warming up so their metrics don't
+		// count toward alarm aggregation right after they're launched
+		warmupCutoff := time.Now().Add(-time.Duration(sp.Warmup) * time.Second)
+		q.Where().LT("vm_created_at", warmupCutoff.Unix())
+	}
 	q.GroupBy().TAG("*").FILL_NULL()
 	return config, nil
 }
@@ -550,9 +813,16 @@ func (sa *SScalingAlarm) TriggerDescription() string {
 }
 
 func (sa *SScalingAlarm) IsTrigger() (is bool) {
+	timer := TimersFor(sa.ScalingPolicyId, "alarm").Start("is_trigger")
+	defer timer.Stop()
+
+	now := time.Now()
+	if sa.inCooldown(now) {
+		timer.Suppressed()
+		return false
+	}
 	realCumulate := sa.RealCumulate
 	lastTriggerTime := sa.LastTriggerTime
-	now := time.Now()
 	if lastTriggerTime.Add(time.Duration(sa.Cycle) * 2 * time.Second).Before(now) {
 		realCumulate = 1
 	} else {
@@ -570,6 +840,11 @@ func (sa *SScalingAlarm) IsTrigger() (is bool) {
 	})
 	if err != nil {
 		log.Errorf("db.Update in ScalingAlarm.IsTrigger failed: %s", err.Error())
+		timer.Failed()
+		return
+	}
+	if is {
+		timer.Succeeded()
 	}
 	return
 }
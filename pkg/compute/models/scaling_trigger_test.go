@@ -0,0 +1,200 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	cf, err := parseCronField("*", 0, 59)
+	if err != nil || !cf.any {
+		t.Fatalf("parseCronField(*) = %+v, %v, want any=true", cf, err)
+	}
+
+	cf, err = parseCronField("1,15,30", 0, 59)
+	if err != nil {
+		t.Fatalf("parseCronField(1,15,30) error: %v", err)
+	}
+	for _, v := range []int{1, 15, 30} {
+		if !cf.match(v, 59) {
+			t.Errorf("expected %d to match", v)
+		}
+	}
+	if cf.match(2, 59) {
+		t.Errorf("expected 2 to not match")
+	}
+
+	cf, err = parseCronField("10-12", 0, 23)
+	if err != nil {
+		t.Fatalf("parseCronField(10-12) error: %v", err)
+	}
+	for v := 10; v <= 12; v++ {
+		if !cf.match(v, 23) {
+			t.Errorf("expected %d in range to match", v)
+		}
+	}
+
+	cf, err = parseCronField("*/15", 0, 59)
+	if err != nil {
+		t.Fatalf("parseCronField(*/15) error: %v", err)
+	}
+	for _, v := range []int{0, 15, 30, 45} {
+		if !cf.match(v, 59) {
+			t.Errorf("expected %d to match */15", v)
+		}
+	}
+	if cf.match(16, 59) {
+		t.Errorf("expected 16 to not match */15")
+	}
+
+	if _, err := parseCronField("60", 0, 59); err == nil {
+		t.Errorf("expected out-of-range value to error")
+	}
+}
+
+func TestCronFieldMatchDow(t *testing.T) {
+	cf, err := parseCronField("0", 0, 7)
+	if err != nil {
+		t.Fatalf("parseCronField(0) error: %v", err)
+	}
+	// cron treats both 0 and 7 as Sunday
+	if !cf.matchDow(0) || !cf.matchDow(7) {
+		t.Errorf("expected dow field 0 to match both weekday 0 and 7")
+	}
+	if cf.matchDow(1) {
+		t.Errorf("expected dow field 0 to not match Monday")
+	}
+}
+
+func TestCronFieldRestricted(t *testing.T) {
+	any, _ := parseCronField("*", 1, 31)
+	if any.restricted() {
+		t.Errorf("'*' should not be restricted")
+	}
+	fixed, _ := parseCronField("1,15", 1, 31)
+	if !fixed.restricted() {
+		t.Errorf("'1,15' should be restricted")
+	}
+}
+
+func TestDayMatchesDomDowDisjunction(t *testing.T) {
+	// "0 0 1,15 * 1" should mean "the 1st/15th OR every Monday", per
+	// standard cron semantics, not an AND of the two fields.
+	spec, err := parseCronExpr("0 0 1,15 * 1")
+	if err != nil {
+		t.Fatalf("parseCronExpr error: %v", err)
+	}
+
+	// 2026-07-20 is a Monday but not the 1st/15th: should match via dow
+	monday := time.Date(2026, 7, 20, 0, 0, 0, 0, time.UTC)
+	if !spec.dayMatches(monday, 31) {
+		t.Errorf("expected Monday 2026-07-20 to match via day-of-week disjunction")
+	}
+
+	// 2026-07-15 is a Wednesday but is the 15th: should match via dom
+	fifteenth := time.Date(2026, 7, 15, 0, 0, 0, 0, time.UTC)
+	if !spec.dayMatches(fifteenth, 31) {
+		t.Errorf("expected the 15th to match via day-of-month disjunction")
+	}
+
+	// 2026-07-21 is a Tuesday and not the 1st/15th: should not match either field
+	tuesday := time.Date(2026, 7, 21, 0, 0, 0, 0, time.UTC)
+	if spec.dayMatches(tuesday, 31) {
+		t.Errorf("expected Tuesday 2026-07-21 to not match")
+	}
+}
+
+func TestDayMatchesSingleRestrictedField(t *testing.T) {
+	// when only dom is restricted, dow="*" must not veto a dom match
+	spec, err := parseCronExpr("0 0 1 * *")
+	if err != nil {
+		t.Fatalf("parseCronExpr error: %v", err)
+	}
+	first := time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)
+	if !spec.dayMatches(first, 31) {
+		t.Errorf("expected the 1st to match when only dom is restricted")
+	}
+	second := time.Date(2026, 8, 2, 0, 0, 0, 0, time.UTC)
+	if spec.dayMatches(second, 31) {
+		t.Errorf("expected the 2nd to not match when only dom is restricted")
+	}
+}
+
+func TestNextCronTime(t *testing.T) {
+	st := &SScalingTimer{}
+	spec, err := parseCronExpr("30 4 * * *")
+	if err != nil {
+		t.Fatalf("parseCronExpr error: %v", err)
+	}
+	from := time.Date(2026, 7, 26, 10, 0, 0, 0, time.UTC)
+	next := st.nextCronTime(spec, from)
+	want := time.Date(2026, 7, 27, 4, 30, 0, 0, time.UTC)
+	if !next.Equal(want) {
+		t.Fatalf("nextCronTime = %v, want %v", next, want)
+	}
+}
+
+func TestMonthDaySum(t *testing.T) {
+	st := &SScalingTimer{}
+	cases := []struct {
+		date time.Time
+		want int
+	}{
+		{time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), 31},
+		{time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), 30},
+		{time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), 30},
+		{time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), 30},
+		{time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), 30},
+		{time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), 28},
+		{time.Date(2028, 2, 1, 0, 0, 0, 0, time.UTC), 29}, // leap year
+	}
+	for _, c := range cases {
+		if got := st.MonthDaySum(c.date); got != c.want {
+			t.Errorf("MonthDaySum(%s) = %d, want %d", c.date.Format("2006-01"), got, c.want)
+		}
+	}
+}
+
+func TestNextCronTimeLastDayOf30DayMonth(t *testing.T) {
+	st := &SScalingTimer{}
+	// "L" in the day-of-month field must resolve to the real last day of
+	// the current month, not a hardcoded 31 -- April only has 30 days.
+	spec, err := parseCronExpr("0 0 L 4 *")
+	if err != nil {
+		t.Fatalf("parseCronExpr error: %v", err)
+	}
+	from := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	next := st.nextCronTime(spec, from)
+	want := time.Date(2026, 4, 30, 0, 0, 0, 0, time.UTC)
+	if !next.Equal(want) {
+		t.Fatalf("nextCronTime = %v, want %v", next, want)
+	}
+}
+
+func TestNextCronTimeNoSolution(t *testing.T) {
+	st := &SScalingTimer{}
+	// Feb never has 30 days, so this expression can never match
+	spec, err := parseCronExpr("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("parseCronExpr error: %v", err)
+	}
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	next := st.nextCronTime(spec, from)
+	if !next.IsZero() {
+		t.Fatalf("nextCronTime = %v, want zero value for an unsatisfiable expression", next)
+	}
+}
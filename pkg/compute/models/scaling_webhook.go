@@ -0,0 +1,307 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/appsrv"
+	"yunion.io/x/onecloud/pkg/appsrv/appctx"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+type SScalingWebhookManager struct {
+	db.SStandaloneResourceBaseManager
+}
+
+// SScalingWebhook implements IScalingTrigger for POST
+// /scalingpolicies/<id>/webhook: an external system (CI pipeline,
+// third-party monitoring, chatops) fires the policy by calling the
+// endpoint directly instead of going through the monitor-alert path
+// SScalingAlarm uses.
+type SScalingWebhook struct {
+	db.SStandaloneResourceBase
+
+	SScalingPolicyBase
+
+	// Secret signs X-Onecloud-Signature (hex HMAC-SHA256 over the raw
+	// request body). Generated on Register, cleared on UnRegister.
+	Secret string `width:"64" charset:"ascii"`
+
+	// BearerToken, when set, lets simpler clients authenticate with
+	// "Authorization: Bearer <token>" instead of computing an HMAC
+	BearerToken string `width:"64" charset:"ascii" nullable:"true"`
+
+	// LastNonce is the highest nonce accepted so far; a request whose
+	// nonce is not strictly greater is rejected as a replay
+	LastNonce int64 `default:"0"`
+
+	// LastInvokeTime/LastPayload record the most recently accepted call
+	LastInvokeTime time.Time
+	LastPayload    string `charset:"utf8" nullable:"true"`
+
+	// Fired is set by ScalingWebhookHandler and consumed by IsTrigger
+	Fired bool `default:"false"`
+}
+
+var ScalingWebhookManager *SScalingWebhookManager
+
+func init() {
+	ScalingWebhookManager = &SScalingWebhookManager{
+		SStandaloneResourceBaseManager: db.NewStandaloneResourceBaseManager(
+			SScalingWebhook{},
+			"scalingwebhooks_tbl",
+			"scalingwebhook",
+			"scalingwebhooks",
+		),
+	}
+	ScalingWebhookManager.SetVirtualObject(ScalingWebhookManager)
+}
+
+func (sw *SScalingWebhook) ValidateCreateData(input api.ScalingPolicyCreateInput) (api.ScalingPolicyCreateInput, error) {
+	return input, nil
+}
+
+func (sw *SScalingWebhook) Register(ctx context.Context, userCred mcclient.TokenCredential) error {
+	t := TimersFor(sw.ScalingPolicyId, "webhook").Start("register")
+	defer t.Stop()
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		t.Failed()
+		return errors.Wrap(err, "generateWebhookSecret")
+	}
+	sw.Secret = secret
+	err = ScalingWebhookManager.TableSpec().Insert(sw)
+	if err != nil {
+		t.Failed()
+		return errors.Wrap(err, "STableSpec.Insert")
+	}
+	t.Succeeded()
+	return nil
+}
+
+func (sw *SScalingWebhook) UnRegister(ctx context.Context, userCred mcclient.TokenCredential) error {
+	t := TimersFor(sw.ScalingPolicyId, "webhook").Start("unregister")
+	defer t.Stop()
+
+	// clear the secret before deleting the row so a signature computed
+	// from a stale read of it can't be replayed afterwards
+	_, err := db.Update(sw, func() error {
+		sw.Secret = ""
+		sw.BearerToken = ""
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "db.Update rotate secret")
+	}
+	err = sw.Delete(ctx, userCred)
+	if err != nil {
+		return errors.Wrap(err, "SScalingWebhook.Delete")
+	}
+	return nil
+}
+
+func (sw *SScalingWebhook) TriggerId() string {
+	return sw.GetId()
+}
+
+func (sw *SScalingWebhook) Endpoint() string {
+	return fmt.Sprintf("/scalingpolicies/%s/webhook", sw.ScalingPolicyId)
+}
+
+func (sw *SScalingWebhook) TriggerDescription() string {
+	name := sw.ScalingPolicyId
+	sp, _ := sw.ScalingPolicy()
+	if sp != nil {
+		name = sp.Name
+	}
+	lastInvoke := "never"
+	if !sw.LastInvokeTime.IsZero() {
+		lastInvoke = sw.LastInvokeTime.In(cstSh).Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprintf(
+		`A signed webhook call to %s execute scaling policy "%s" (last invoked: %s)`,
+		sw.Endpoint(), name, lastInvoke,
+	)
+}
+
+// IsTrigger consumes the Fired flag ScalingWebhookHandler set, so a single
+// accepted call fires the policy exactly once.
+func (sw *SScalingWebhook) IsTrigger() bool {
+	timer := TimersFor(sw.ScalingPolicyId, "webhook").Start("is_trigger")
+	defer timer.Stop()
+
+	if sw.inCooldown(time.Now()) {
+		timer.Suppressed()
+		return false
+	}
+	if !sw.Fired {
+		return false
+	}
+	_, err := db.Update(sw, func() error {
+		sw.Fired = false
+		return nil
+	})
+	if err != nil {
+		log.Errorf("db.Update in SScalingWebhook.IsTrigger failed: %s", err.Error())
+		timer.Failed()
+		return false
+	}
+	timer.Succeeded()
+	return true
+}
+
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "rand.Read")
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// verifyRequest checks the signature or bearer credential of an incoming
+// webhook call against rawBody -- the literal bytes the caller sent and
+// signed, not a re-marshaled representation of them -- and only once
+// that succeeds checks the nonce for replay. Authentication is checked
+// first so that an unauthenticated caller never learns LastNonce (it
+// would otherwise let anyone who knows a policy ID probe the last
+// accepted nonce without ever proving the secret). Bearer mode is used
+// whenever BearerToken is set; otherwise the caller must supply a valid
+// HMAC-SHA256 signature of rawBody under Secret.
+func (sw *SScalingWebhook) verifyRequest(rawBody, signature, bearer string, nonce int64) error {
+	if len(sw.BearerToken) > 0 {
+		if !hmac.Equal([]byte(bearer), []byte(sw.BearerToken)) {
+			return fmt.Errorf("invalid bearer token")
+		}
+	} else {
+		if len(signature) == 0 {
+			return fmt.Errorf("missing X-Onecloud-Signature header")
+		}
+		mac := hmac.New(sha256.New, []byte(sw.Secret))
+		mac.Write([]byte(rawBody))
+		expected := hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(signature), []byte(expected)) {
+			return fmt.Errorf("signature mismatch")
+		}
+	}
+	if nonce <= sw.LastNonce {
+		return fmt.Errorf("nonce has already been used")
+	}
+	return nil
+}
+
+func (sw *SScalingWebhook) recordInvocation(nonce int64, payload string) error {
+	_, err := db.Update(sw, func() error {
+		sw.LastNonce = nonce
+		sw.LastInvokeTime = time.Now()
+		sw.LastPayload = payload
+		sw.Fired = true
+		return nil
+	})
+	return err
+}
+
+func (sp *SScalingPolicy) scalingWebhook() (*SScalingWebhook, error) {
+	q := ScalingWebhookManager.Query().Equals("scaling_policy_id", sp.Id)
+	var sw SScalingWebhook
+	err := q.First(&sw)
+	if err != nil {
+		return nil, errors.Wrap(err, "ScalingWebhookManager.Query")
+	}
+	return &sw, nil
+}
+
+// webhookRequestBody is the JSON shape ScalingWebhookHandler's nonce/payload
+// come from, parsed from the same raw bytes the signature was verified
+// against rather than from a re-marshaled jsonutils.JSONObject.
+type webhookRequestBody struct {
+	Nonce   int64  `json:"nonce"`
+	Payload string `json:"payload"`
+}
+
+// ScalingWebhookHandler implements POST /scalingpolicies/<id>/webhook as
+// a raw HTTP handler rather than a jsonutils-dispatched Perform* action.
+// The HMAC signature must be verified against the exact bytes the
+// external caller signed; re-serializing a JSONObject the framework has
+// already decoded cannot be relied on to reproduce them byte-for-byte
+// (key order, number formatting, ...), so the raw body has to be read
+// before any JSON decoding happens.
+func ScalingWebhookHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	policyId := appctx.AppContextParams(ctx)["<id>"]
+	obj, err := ScalingPolicyManager.FetchById(policyId)
+	if err != nil {
+		httperrors.NotFoundError(ctx, w, "scaling policy %s not found", policyId)
+		return
+	}
+	sp := obj.(*SScalingPolicy)
+	sw, err := sp.scalingWebhook()
+	if err != nil {
+		httperrors.NotFoundError(ctx, w, "no webhook trigger registered for this policy")
+		return
+	}
+
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httperrors.GeneralServerError(ctx, w, errors.Wrap(err, "read request body"))
+		return
+	}
+	var body webhookRequestBody
+	if len(rawBody) > 0 {
+		if err := json.Unmarshal(rawBody, &body); err != nil {
+			httperrors.InputParameterError(ctx, w, "invalid JSON body: %s", err)
+			return
+		}
+	}
+
+	signature := r.Header.Get("X-Onecloud-Signature")
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err := sw.verifyRequest(string(rawBody), signature, bearer, body.Nonce); err != nil {
+		httperrors.ForbiddenError(ctx, w, "%s", err)
+		return
+	}
+
+	if err := sw.recordInvocation(body.Nonce, body.Payload); err != nil {
+		httperrors.GeneralServerError(ctx, w, err)
+		return
+	}
+	appsrv.SendJSON(w, jsonutils.NewDict())
+}
+
+// AddScalingWebhookHandler registers POST /scalingpolicies/<id>/webhook
+// with app as a raw handler, bypassing the JSON Perform* dispatch the
+// rest of the scaling policy sub-resources use (see ScalingWebhookHandler
+// for why). Call this alongside the other scaling policy sub-resource
+// registrations in the compute service's handler setup.
+func AddScalingWebhookHandler(prefix string, app *appsrv.Application) {
+	app.AddHandler("POST", fmt.Sprintf("%s/scalingpolicies/<id>/webhook", prefix), ScalingWebhookHandler)
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyRequestSignature(t *testing.T) {
+	sw := &SScalingWebhook{Secret: "s3cr3t"}
+	body := `{"nonce":1,"payload":"ok"}`
+
+	if err := sw.verifyRequest(body, sign("s3cr3t", body), "", 1); err != nil {
+		t.Errorf("expected a valid signature to be accepted, got: %v", err)
+	}
+	if err := sw.verifyRequest(body, sign("wrong-secret", body), "", 2); err == nil {
+		t.Errorf("expected an invalid signature to be rejected")
+	}
+	if err := sw.verifyRequest(body, "", "", 3); err == nil {
+		t.Errorf("expected a missing signature to be rejected")
+	}
+}
+
+func TestVerifyRequestReplay(t *testing.T) {
+	sw := &SScalingWebhook{Secret: "s3cr3t", LastNonce: 5}
+	body := `{"nonce":5,"payload":"ok"}`
+
+	if err := sw.verifyRequest(body, sign("s3cr3t", body), "", 5); err == nil {
+		t.Errorf("expected a nonce equal to LastNonce to be rejected as a replay")
+	}
+	if err := sw.verifyRequest(body, sign("s3cr3t", body), "", 4); err == nil {
+		t.Errorf("expected a nonce lower than LastNonce to be rejected as a replay")
+	}
+	if err := sw.verifyRequest(body, sign("s3cr3t", body), "", 6); err != nil {
+		t.Errorf("expected a nonce greater than LastNonce to be accepted, got: %v", err)
+	}
+}
+
+func TestVerifyRequestBearerMode(t *testing.T) {
+	sw := &SScalingWebhook{Secret: "s3cr3t", BearerToken: "tok3n"}
+	body := `{"nonce":1,"payload":"ok"}`
+
+	// bearer mode ignores the HMAC signature entirely
+	if err := sw.verifyRequest(body, "", "tok3n", 1); err != nil {
+		t.Errorf("expected a valid bearer token to be accepted, got: %v", err)
+	}
+	if err := sw.verifyRequest(body, "", "wrong-token", 2); err == nil {
+		t.Errorf("expected an invalid bearer token to be rejected")
+	}
+}